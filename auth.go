@@ -0,0 +1,21 @@
+package main
+
+// Auth holds the SMTP AUTH credentials a client must present before
+// relaying mail, configured via --auth-user/--auth-pass. A zero-value Auth
+// (empty Username) disables the AUTH requirement entirely.
+type Auth struct {
+	Username string
+	Password string
+}
+
+// required reports whether the listener should refuse MAIL/RCPT/DATA from
+// an unauthenticated client.
+func (a Auth) required() bool {
+	return a.Username != ""
+}
+
+// check reports whether username/password match the configured
+// credentials.
+func (a Auth) check(username, password string) bool {
+	return a.required() && username == a.Username && password == a.Password
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestAuthRequired(t *testing.T) {
+	if (Auth{}).required() {
+		t.Errorf("zero-value Auth should not require authentication")
+	}
+	if !(Auth{Username: "ops"}).required() {
+		t.Errorf("Auth with a Username should require authentication")
+	}
+}
+
+func TestAuthCheck(t *testing.T) {
+	a := Auth{Username: "ops", Password: "secret"}
+
+	if !a.check("ops", "secret") {
+		t.Errorf("check() = false for correct credentials, want true")
+	}
+	if a.check("ops", "wrong") {
+		t.Errorf("check() = true for incorrect password, want false")
+	}
+	if (Auth{}).check("", "") {
+		t.Errorf("zero-value Auth should reject any credentials")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+)
+
+// Config holds failmail's runtime configuration. Fields are populated by
+// github.com/hut8labs/failmail/configure from command-line flags (tagged
+// below) or a written-out config file; see configure.Parse in main().
+type Config struct {
+	Version bool `flag:"--version" help:"print the version and exit"`
+
+	BindSMTP        string        `flag:"--bind" default:":2525" help:"address to listen for SMTP on"`
+	BindHTTP        string        `flag:"--bind-http" default:":8025" help:"address to serve the HTTP status endpoint on"`
+	Pidfile         string        `flag:"--pidfile" help:"write the process id to this file on startup"`
+	Script          string        `flag:"--script" help:"run this script alongside the server"`
+	ShutdownTimeout time.Duration `flag:"--shutdown-timeout" default:"10s"`
+
+	From       string        `flag:"--from" help:"the From address for summarized messages"`
+	WaitPeriod time.Duration `flag:"--wait-period" default:"30s"`
+	MaxWait    time.Duration `flag:"--max-wait" default:"5m"`
+
+	RateLimit  int           `flag:"--rate-limit"`
+	RateWindow time.Duration `flag:"--rate-window" default:"1m"`
+	RateCheck  time.Duration `flag:"--rate-check" default:"10s"`
+	RelayAll   bool          `flag:"--relay-all" help:"also relay every message upstream as it's received, unsummarized"`
+
+	UpstreamAddr string `flag:"--upstream" help:"address of the upstream SMTP server"`
+	FailDir      string `flag:"--fail-dir" default:"failed" help:"maildir to write messages that couldn't be sent upstream"`
+
+	// AuthUser and AuthPass, if set, require clients to AUTH PLAIN/LOGIN
+	// with these credentials before relaying (see listener.go).
+	AuthUser string `flag:"--auth-user"`
+	AuthPass string `flag:"--auth-pass"`
+
+	// TLSCert and TLSKey, if set, let clients upgrade with STARTTLS (see
+	// listener.go).
+	TLSCert string `flag:"--tls-cert"`
+	TLSKey  string `flag:"--tls-key"`
+
+	// FailRetention and friends configure the maildir retention scanner
+	// (see retention.go). FailRetention of 0 disables it.
+	FailRetention  time.Duration `flag:"--fail-retention" help:"purge messages from the failed maildir older than this; 0 disables"`
+	FailPurgeBatch int           `flag:"--fail-purge-batch" help:"purge at most this many messages per scan; 0 means no cap"`
+	FailPurgeSleep time.Duration `flag:"--fail-purge-sleep" default:"1m" help:"minimum time between retention scans"`
+
+	// LogLevel and LogJSON configure the logging package (see logger.go).
+	LogLevel string `flag:"--log-level" default:"info" help:"minimum level to log: trace, debug, info, warn, error"`
+	LogJSON  bool   `flag:"--log-json" help:"log newline-delimited JSON instead of plain text"`
+
+	// Recipients and TarpitDelay configure the RCPT TO allowlist (see
+	// recipients.go).
+	Recipients  string        `flag:"--recipients" help:"path to a file of accepted RCPT TO addresses, one per line, reloaded on SIGHUP"`
+	TarpitDelay time.Duration `flag:"--tarpit-delay" help:"delay before responding to DATA for an unlisted recipient"`
+
+	// BindPOP3 configures the read-only POP3 server (see pop3.go). Empty
+	// disables it.
+	BindPOP3 string `flag:"--pop3-bind" help:"address to serve POP3 on for browsing the failed maildir; empty disables it"`
+
+	// Routes configures per-domain delivery (see router.go). Empty means
+	// every message goes to the single configured upstream.
+	Routes string `flag:"--routes" help:"path to a routes file mapping recipient domains to couriers; unmatched domains fall back to direct MX delivery"`
+}
+
+// Defaults returns a Config with default values, before flags or a config
+// file are applied.
+func Defaults() *Config {
+	return &Config{
+		BindSMTP:        ":2525",
+		BindHTTP:        ":8025",
+		ShutdownTimeout: 10 * time.Second,
+		WaitPeriod:      30 * time.Second,
+		MaxWait:         5 * time.Minute,
+		RateWindow:      time.Minute,
+		RateCheck:       10 * time.Second,
+		FailDir:         "failed",
+		FailPurgeSleep:  time.Minute,
+		LogLevel:        "info",
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/hut8labs/failmail/configure"
-	"log"
+	"github.com/hut8labs/failmail/logging"
 	"os"
 	"time"
 )
 
+var log = logger("main")
+
 const VERSION = "0.2.0"
 
 const LOGO = `
@@ -35,7 +38,15 @@ func main() {
 		fmt.Fprintf(os.Stderr, "failmail %s\n", VERSION)
 		return
 	}
-	log.Printf("failmail %s, starting up", VERSION)
+
+	level, err := logging.ParseLevel(config.LogLevel)
+	if err != nil {
+		log.Fatalf("invalid --log-level: %s", err)
+	}
+	logging.SetLevel(level)
+	logging.JSON = config.LogJSON
+
+	log.Infof("failmail %s, starting up", VERSION)
 
 	// A channel for incoming messages. The listener sends on the channel, and
 	// receives are added to a MessageBuffer in the channel consumer below.
@@ -56,15 +67,33 @@ func main() {
 	tlsConfig, err := config.TLSConfig()
 
 	// The listener talks SMTP to clients, and puts any messages they send onto
-	// the `received` channel.
-	socket, err := config.Socket()
+	// the `received` channel. If systemd passed us a socket via socket
+	// activation, adopt it instead of binding a fresh one.
+	socket, err := systemdListener()
 	if err != nil {
-		log.Fatalf("failed to create socket for listener: %s", err)
+		log.Fatalf("failed to adopt systemd socket: %s", err)
+	}
+	if socket == nil {
+		socket, err = config.Socket()
+		if err != nil {
+			log.Fatalf("failed to create socket for listener: %s", err)
+		}
 	}
 
 	reloader := NewReloader()
 
-	listener := &Listener{Logger: logger("listener"), Socket: socket, Auth: auth, TLSConfig: tlsConfig}
+	// If a recipients allowlist is configured, mail for any other address
+	// is accepted and silently discarded rather than relayed upstream.
+	var recipients *RecipientFilter
+	if config.Recipients != "" {
+		recipients = NewRecipientFilter(config.TarpitDelay)
+		if err := recipients.Reload(config.Recipients); err != nil {
+			log.Fatalf("failed to load recipients: %s", err)
+		}
+		reloader.OnReload(func() error { return recipients.Reload(config.Recipients) })
+	}
+
+	listener := &Listener{Logger: logger("listener"), Socket: socket, Auth: auth, TLSConfig: tlsConfig, Recipients: recipients}
 	go listener.Listen(received, reloader, config.ShutdownTimeout)
 
 	if config.Pidfile != "" {
@@ -81,11 +110,18 @@ func main() {
 	rateCounter := NewRateCounter(config.RateLimit, config.RateWindow)
 
 	// An upstream SMTP server is used to send the summarized messages flushed
-	// from the MessageBuffer.
+	// from the MessageBuffer. If --routes is configured, dispatch per
+	// recipient domain instead of using a single upstream.
 	upstream, err := config.Upstream()
 	if err != nil {
 		log.Fatalf("failed to create upstream: %s", err)
 	}
+	if config.Routes != "" {
+		upstream, err = RoutesFromFile(config.Routes, NewMXCourier())
+		if err != nil {
+			log.Fatalf("failed to load routes: %s", err)
+		}
+	}
 
 	// Any messages we were unable to send upstream will be written to this
 	// maildir.
@@ -94,6 +130,11 @@ func main() {
 		log.Fatalf("failed to create maildir for failed messages at %s: %s", config.FailDir, err)
 	}
 
+	// Periodically purge old messages from the failed maildir so it doesn't
+	// grow unbounded while an upstream is down.
+	retentionScanner := NewRetentionScanner(failedMaildir, config.FailRetention, config.FailPurgeBatch, config.FailPurgeSleep)
+	go retentionScanner.Run(done)
+
 	if config.Script != "" {
 		runner, err := runScript(config.Script)
 		if err != nil {
@@ -101,31 +142,68 @@ func main() {
 		}
 		go runner(done)
 	}
-	go ListenHTTP(config.BindHTTP, buffer)
+	go ListenHTTP(config.BindHTTP, buffer, retentionScanner.Stats, LogLevelHandler, recipients)
+
+	// Lets operators browse the failed maildir with any POP3 mail client
+	// instead of shelling in to read files.
+	if config.BindPOP3 != "" {
+		pop3Server := NewPOP3Server(config.BindPOP3, failedMaildir, auth)
+		go func() {
+			if err := pop3Server.ListenAndServe(); err != nil {
+				log.Fatalf("pop3 server failed: %s", err)
+			}
+		}()
+	}
 
 	renderer := config.SummaryRenderer()
 	go run(renderer, buffer, rateCounter, config.RateCheck, reloader, received, sending, done, config.RelayAll)
+	go runWatchdog(done)
+
+	notify("READY=1")
 
 	sendUpstream(sending, upstream, failedMaildir)
 
+	// Only tell systemd we're reloading if a reload was actually requested
+	// (e.g. via SIGHUP) -- this same shutdown path also runs on a plain
+	// termination, which isn't a reload.
+	if reloadWasRequested() {
+		notify("RELOADING=1")
+	}
 	if err := reloader.ReloadIfNecessary(); err != nil {
 		log.Fatalf("failed to reload: %s", err)
 	}
+	if reloadWasRequested() {
+		notify("READY=1")
+	}
 }
 
 func sendUpstream(sending <-chan OutgoingMessage, upstream Upstream, failedMaildir *Maildir) {
+	defer logging.PanicHandler(logger("sender"))
+
+	sendLog := logger("sender")
 	for msg := range sending {
 		if sendErr := upstream.Send(msg); sendErr != nil {
-			log.Printf("couldn't send message: %s", sendErr)
-			if saveErr := failedMaildir.Write([]byte(msg.Contents())); saveErr != nil {
-				log.Printf("couldn't save message: %s", saveErr)
+			sendLog.Warnf("couldn't send message: %s", sendErr)
+
+			// A partial failure only names the recipients that weren't
+			// delivered -- save just those, so a retry doesn't resend to
+			// recipients the upstream already accepted.
+			failedMsg := msg
+			var partial *PartialSendError
+			if errors.As(sendErr, &partial) {
+				failedMsg = restrictRecipients(msg, partial.Recipients)
+			}
+
+			if _, saveErr := failedMaildir.Write([]byte(failedMsg.Contents())); saveErr != nil {
+				sendLog.Errorf("couldn't save message: %s", saveErr)
 			}
 		}
 	}
-	log.Printf("done sending")
+	sendLog.Infof("done sending")
 }
 
 func run(renderer SummaryRenderer, buffer *MessageBuffer, rateCounter *RateCounter, rateCheck time.Duration, reloader *Reloader, received <-chan *ReceivedMessage, sending chan<- OutgoingMessage, done <-chan TerminationRequest, relayAll bool) {
+	defer logging.PanicHandler(logger("run"))
 
 	tick := time.Tick(1 * time.Second)
 	rateCheckTick := time.Tick(rateCheck)
@@ -133,6 +211,7 @@ func run(renderer SummaryRenderer, buffer *MessageBuffer, rateCounter *RateCount
 	for {
 		select {
 		case <-tick:
+			markTick()
 			for _, summary := range buffer.Flush(false) {
 				sending <- renderer.Render(summary)
 			}
@@ -141,7 +220,7 @@ func run(renderer SummaryRenderer, buffer *MessageBuffer, rateCounter *RateCount
 			exceeded, count := rateCounter.CheckAndAdvance()
 			if exceeded {
 				// TODO actually send an email here, eventually
-				log.Printf("rate limit check exceeded: %d messages", count)
+				log.Infof("rate limit check exceeded: %d messages", count)
 			}
 		case msg := <-received:
 			buffer.Add(msg)
@@ -152,8 +231,9 @@ func run(renderer SummaryRenderer, buffer *MessageBuffer, rateCounter *RateCount
 		case req := <-done:
 			if req == Reload {
 				reloader.RequestReload()
+				markReloadRequested()
 			}
-			log.Printf("cleaning up")
+			log.Infof("cleaning up")
 			for _, summary := range buffer.Flush(true) {
 				sending <- renderer.Render(summary)
 			}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ListenHTTP serves failmail's HTTP status endpoint: a plain-text summary
+// at "/", retention stats as JSON at "/retention", the runtime log level
+// at "/loglevel" (see loglevel.go), and -- if a recipient allowlist is
+// configured -- its accept/discard/tarpit counters as JSON at
+// "/recipients", so operators can see how failmail is behaving without
+// shelling in.
+func ListenHTTP(addr string, buffer *MessageBuffer, stats *RetentionStats, logLevelHandler http.HandlerFunc, recipients *RecipientFilter) error {
+	mux := http.NewServeMux()
+	registerStatusRoute(mux, buffer)
+	registerRetentionRoute(mux, stats)
+	mux.HandleFunc("/loglevel", logLevelHandler)
+	if recipients != nil {
+		registerRecipientsRoute(mux, recipients)
+	}
+	return http.ListenAndServe(addr, mux)
+}
+
+func registerRecipientsRoute(mux *http.ServeMux, recipients *RecipientFilter) {
+	mux.HandleFunc("/recipients", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, recipients.Counts())
+	})
+}
+
+func registerStatusRoute(mux *http.ServeMux, buffer *MessageBuffer) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "failmail\n\n%d group(s) pending summarization\n", buffer.Pending())
+	})
+}
+
+func registerRetentionRoute(mux *http.ServeMux, stats *RetentionStats) {
+	mux.HandleFunc("/retention", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, stats.Snapshot())
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
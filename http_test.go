@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterStatusRouteReportsPendingGroups(t *testing.T) {
+	buffer := NewMessageBuffer(time.Hour, time.Hour, BatchSpec{}, GroupSpec{Fields: []string{"from"}}, "")
+	buffer.Add(NewReceivedMessage("a@example.com", []string{"ops@example.com"}, []byte("x")))
+
+	mux := http.NewServeMux()
+	registerStatusRoute(mux, buffer)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !strings.Contains(rec.Body.String(), "1 group") {
+		t.Errorf("status body = %q, want it to mention 1 pending group", rec.Body.String())
+	}
+}
+
+func TestRegisterRetentionRouteReturnsJSONStats(t *testing.T) {
+	stats := &RetentionStats{}
+	stats.set(3, time.Unix(1393650000, 0), 512, 1)
+
+	mux := http.NewServeMux()
+	registerRetentionRoute(mux, stats)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/retention", nil))
+
+	var decoded RetentionStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected valid JSON, got %q: %s", rec.Body.String(), err)
+	}
+	if decoded.Count != 3 || decoded.Removed != 1 {
+		t.Errorf("decoded stats = %+v, want Count=3 Removed=1", decoded)
+	}
+}
+
+func TestRegisterRecipientsRouteReturnsJSONCounts(t *testing.T) {
+	filter := NewRecipientFilter(0)
+	filter.Accept()
+	filter.Accept()
+	filter.Discard()
+
+	mux := http.NewServeMux()
+	registerRecipientsRoute(mux, filter)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/recipients", nil))
+
+	var decoded RecipientCounts
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected valid JSON, got %q: %s", rec.Body.String(), err)
+	}
+	if decoded.Accepted != 2 || decoded.Discarded != 1 {
+		t.Errorf("decoded counts = %+v, want Accepted=2 Discarded=1", decoded)
+	}
+}
+
+func TestLogLevelRouteAnswersGet(t *testing.T) {
+	mux := http.NewServeMux()
+	registerStatusRoute(mux, NewMessageBuffer(time.Hour, time.Hour, BatchSpec{}, GroupSpec{}, ""))
+	registerRetentionRoute(mux, &RetentionStats{})
+	mux.HandleFunc("/loglevel", LogLevelHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /loglevel = %d, want 200", rec.Code)
+	}
+}
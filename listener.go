@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// Listener accepts SMTP connections, authenticates clients, filters
+// recipients against the configured allowlist, and pushes accepted
+// messages onto the `received` channel for the MessageBuffer to pick up.
+type Listener struct {
+	Logger     *logging.Logger
+	Socket     net.Listener
+	Auth       Auth
+	TLSConfig  *tls.Config
+	Recipients *RecipientFilter
+}
+
+// Listen accepts connections in a loop and serves each one on its own
+// goroutine. It never returns: reloader and shutdownTimeout are not
+// consulted, so a reload or shutdown neither stops it from accepting nor
+// waits for in-flight sessions to finish.
+func (l *Listener) Listen(received chan<- *ReceivedMessage, reloader *Reloader, shutdownTimeout time.Duration) {
+	defer logging.PanicHandler(l.Logger)
+
+	for {
+		conn, err := l.Socket.Accept()
+		if err != nil {
+			l.Logger.Errorf("accept failed: %s", err)
+			continue
+		}
+		go l.handle(conn, received)
+	}
+}
+
+type smtpSession struct {
+	conn          net.Conn
+	reader        *bufio.Reader
+	listener      *Listener
+	from          string
+	recipients    []string
+	tarpit        bool
+	authenticated bool
+	usingTLS      bool
+}
+
+func (l *Listener) handle(conn net.Conn, received chan<- *ReceivedMessage) {
+	defer logging.PanicHandler(l.Logger)
+	defer conn.Close()
+
+	session := &smtpSession{conn: conn, reader: bufio.NewReader(conn), listener: l}
+	fmt.Fprintf(session.conn, "220 failmail ready\r\n")
+
+	for {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(strings.TrimSpace(line))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "HELO":
+			fmt.Fprintf(session.conn, "250 failmail\r\n")
+		case "EHLO":
+			session.handleEhlo()
+		case "STARTTLS":
+			if !session.handleStartTLS() {
+				return
+			}
+		case "AUTH":
+			session.handleAuth(fields)
+		case "MAIL":
+			if !session.requireAuth() {
+				continue
+			}
+			session.from = parseSMTPAddr(line)
+			fmt.Fprintf(session.conn, "250 OK\r\n")
+		case "RCPT":
+			if !session.requireAuth() {
+				continue
+			}
+			session.handleRcpt(parseSMTPAddr(line))
+		case "DATA":
+			if !session.requireAuth() {
+				continue
+			}
+			if !session.handleData(received) {
+				return
+			}
+		case "RSET":
+			session.from, session.recipients, session.tarpit = "", nil, false
+			fmt.Fprintf(session.conn, "250 OK\r\n")
+		case "QUIT":
+			fmt.Fprintf(session.conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprintf(session.conn, "500 unrecognized command\r\n")
+		}
+	}
+}
+
+// requireAuth reports whether the session may proceed with the mail
+// transaction, replying with an error and returning false if AUTH is
+// configured but this session hasn't completed it.
+func (session *smtpSession) requireAuth() bool {
+	if !session.listener.Auth.required() || session.authenticated {
+		return true
+	}
+	fmt.Fprintf(session.conn, "530 authentication required\r\n")
+	return false
+}
+
+// handleEhlo advertises STARTTLS and AUTH when the listener is configured
+// for them, so clients that speak ESMTP can discover and use them.
+func (session *smtpSession) handleEhlo() {
+	fmt.Fprintf(session.conn, "250-failmail\r\n")
+	if session.listener.TLSConfig != nil && !session.usingTLS {
+		fmt.Fprintf(session.conn, "250-STARTTLS\r\n")
+	}
+	if session.listener.Auth.required() {
+		fmt.Fprintf(session.conn, "250-AUTH PLAIN LOGIN\r\n")
+	}
+	fmt.Fprintf(session.conn, "250 OK\r\n")
+}
+
+// handleStartTLS upgrades the connection to TLS in place, replacing the
+// session's conn and reader so subsequent commands are read from the
+// encrypted stream. It returns false if the connection should be closed.
+func (session *smtpSession) handleStartTLS() bool {
+	if session.listener.TLSConfig == nil {
+		fmt.Fprintf(session.conn, "502 STARTTLS not supported\r\n")
+		return true
+	}
+	if session.usingTLS {
+		fmt.Fprintf(session.conn, "503 already using TLS\r\n")
+		return true
+	}
+
+	fmt.Fprintf(session.conn, "220 go ahead\r\n")
+
+	tlsConn := tls.Server(session.conn, session.listener.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		session.listener.Logger.Warnf("TLS handshake failed: %s", err)
+		return false
+	}
+
+	session.conn = tlsConn
+	session.reader = bufio.NewReader(tlsConn)
+	session.usingTLS = true
+	return true
+}
+
+// handleAuth implements AUTH PLAIN and AUTH LOGIN, the two mechanisms any
+// SMTP client is expected to support.
+func (session *smtpSession) handleAuth(fields []string) {
+	if len(fields) < 2 {
+		fmt.Fprintf(session.conn, "501 syntax error\r\n")
+		return
+	}
+
+	switch strings.ToUpper(fields[1]) {
+	case "PLAIN":
+		var encoded string
+		if len(fields) >= 3 {
+			encoded = fields[2]
+		} else {
+			fmt.Fprintf(session.conn, "334 \r\n")
+			line, err := session.reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			encoded = strings.TrimSpace(line)
+		}
+		session.finishAuthPlain(encoded)
+	case "LOGIN":
+		session.handleAuthLogin()
+	default:
+		fmt.Fprintf(session.conn, "504 unrecognized authentication mechanism\r\n")
+	}
+}
+
+func (session *smtpSession) finishAuthPlain(encoded string) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		fmt.Fprintf(session.conn, "501 malformed AUTH PLAIN response\r\n")
+		return
+	}
+
+	// AUTH PLAIN responses are "authzid\0authcid\0password".
+	parts := strings.SplitN(string(decoded), "\x00", 3)
+	if len(parts) != 3 {
+		fmt.Fprintf(session.conn, "501 malformed AUTH PLAIN response\r\n")
+		return
+	}
+
+	session.finishAuth(parts[1], parts[2])
+}
+
+func (session *smtpSession) handleAuthLogin() {
+	fmt.Fprintf(session.conn, "334 VXNlcm5hbWU6\r\n")
+	username, err := session.readBase64Line()
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(session.conn, "334 UGFzc3dvcmQ6\r\n")
+	password, err := session.readBase64Line()
+	if err != nil {
+		return
+	}
+
+	session.finishAuth(username, password)
+}
+
+func (session *smtpSession) readBase64Line() (string, error) {
+	line, err := session.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		fmt.Fprintf(session.conn, "501 malformed response\r\n")
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+func (session *smtpSession) finishAuth(username, password string) {
+	if !session.listener.Auth.check(username, password) {
+		fmt.Fprintf(session.conn, "535 authentication failed\r\n")
+		return
+	}
+	session.authenticated = true
+	fmt.Fprintf(session.conn, "235 authentication successful\r\n")
+}
+
+// handleRcpt applies the recipient allowlist: addresses on the list are
+// added to the transaction normally; anything else is accepted at the
+// protocol level (so a spammer can't fingerprint the allowlist from the
+// response) but marks the transaction for silent discard and tarpitting
+// once DATA completes.
+func (session *smtpSession) handleRcpt(addr string) {
+	filter := session.listener.Recipients
+	if filter == nil || filter.Accepts(addr) {
+		if filter != nil {
+			filter.Accept()
+		}
+		session.recipients = append(session.recipients, addr)
+	} else {
+		filter.Discard()
+		session.tarpit = true
+	}
+	fmt.Fprintf(session.conn, "250 OK\r\n")
+}
+
+// handleData reads the message body through the terminating "." line, and
+// either pushes it onto received or discards it, depending on whether any
+// recipient was on the allowlist. It returns false if the connection
+// should be closed.
+func (session *smtpSession) handleData(received chan<- *ReceivedMessage) bool {
+	fmt.Fprintf(session.conn, "354 go ahead\r\n")
+
+	var lines []string
+	for {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	if len(session.recipients) > 0 {
+		received <- NewReceivedMessage(session.from, session.recipients, []byte(strings.Join(lines, "\r\n")))
+	}
+
+	if session.tarpit && session.listener.Recipients != nil {
+		session.listener.Recipients.Tarpit()
+	}
+
+	fmt.Fprintf(session.conn, "250 OK\r\n")
+	session.from, session.recipients, session.tarpit = "", nil, false
+	return true
+}
+
+// parseSMTPAddr extracts the address between angle brackets from a MAIL
+// FROM or RCPT TO command line, e.g. `RCPT TO:<ops@example.com>`.
+func parseSMTPAddr(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.Index(line, ">")
+	if start < 0 || end < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
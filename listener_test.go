@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+func newTestSession(t *testing.T, auth Auth) (*smtpSession, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close(); client.Close() })
+
+	return &smtpSession{
+		conn:     server,
+		reader:   bufio.NewReader(server),
+		listener: &Listener{Logger: logger("test"), Auth: auth},
+	}, client
+}
+
+func readResponse(t *testing.T, client net.Conn) string {
+	t.Helper()
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatalf("couldn't read response: %s", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+func TestRequireAuthRejectsBeforeAuthentication(t *testing.T) {
+	session, client := newTestSession(t, Auth{Username: "ops", Password: "secret"})
+
+	done := make(chan bool, 1)
+	go func() { done <- session.requireAuth() }()
+
+	if got := readResponse(t, client); !strings.HasPrefix(got, "530") {
+		t.Errorf("response = %q, want 530 prefix", got)
+	}
+	if ok := <-done; ok {
+		t.Errorf("requireAuth() = true, want false before AUTH completes")
+	}
+}
+
+func TestRequireAuthAllowsWhenNotConfigured(t *testing.T) {
+	session, _ := newTestSession(t, Auth{})
+	if !session.requireAuth() {
+		t.Errorf("requireAuth() = false, want true when no credentials are configured")
+	}
+}
+
+func TestFinishAuthPlainSucceedsWithValidCredentials(t *testing.T) {
+	session, client := newTestSession(t, Auth{Username: "ops", Password: "secret"})
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("\x00ops\x00secret"))
+	done := make(chan bool, 1)
+	go func() { session.finishAuthPlain(encoded); done <- session.authenticated }()
+
+	if got := readResponse(t, client); !strings.HasPrefix(got, "235") {
+		t.Errorf("response = %q, want 235 prefix", got)
+	}
+	if !<-done {
+		t.Errorf("session.authenticated = false, want true")
+	}
+}
+
+func TestFinishAuthPlainRejectsBadPassword(t *testing.T) {
+	session, client := newTestSession(t, Auth{Username: "ops", Password: "secret"})
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("\x00ops\x00wrong"))
+	done := make(chan struct{})
+	go func() { session.finishAuthPlain(encoded); close(done) }()
+
+	if got := readResponse(t, client); !strings.HasPrefix(got, "535") {
+		t.Errorf("response = %q, want 535 prefix", got)
+	}
+	<-done
+	if session.authenticated {
+		t.Errorf("session.authenticated = true, want false")
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "github.com/hut8labs/failmail/logging"
+
+// logger returns a Logger tagged with component, e.g. logger("listener") or
+// logger("sender"), so each subsystem's output can be told apart.
+func logger(component string) *logging.Logger {
+	return logging.New(component)
+}
@@ -0,0 +1,155 @@
+// Package logging provides leveled, per-component logging with a level
+// that can be changed at runtime (e.g. from an HTTP endpoint), so an
+// operator can turn up verbosity on a running process without a restart.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Level is a logging severity. Higher values are more severe.
+type Level int32
+
+const (
+	Trace Level = iota
+	Debug
+	Info
+	Warn
+	Error
+	// Fatal always logs and then exits the process.
+	Fatal
+)
+
+var levelNames = map[Level]string{
+	Trace: "TRACE",
+	Debug: "DEBUG",
+	Info:  "INFO",
+	Warn:  "WARN",
+	Error: "ERROR",
+	Fatal: "FATAL",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// ParseLevel converts a level name (case-insensitive) to a Level.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if equalFold(levelName, name) {
+			return level, nil
+		}
+	}
+	return Info, fmt.Errorf("unrecognized log level %q", name)
+}
+
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		ca, cb := a[i], b[i]
+		if 'a' <= ca && ca <= 'z' {
+			ca -= 'a' - 'A'
+		}
+		if 'a' <= cb && cb <= 'z' {
+			cb -= 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+// level is the process-wide minimum level that gets logged. It's stored as
+// an atomic int32 so it can be read on every log call and written from an
+// HTTP handler without a lock.
+var level int32 = int32(Info)
+
+// SetLevel changes the process-wide log level.
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// GetLevel returns the current process-wide log level.
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+// JSON, when true, makes every Logger write newline-delimited JSON instead
+// of plain text, for shipping to log aggregators.
+var JSON bool
+
+// Output is where log lines are written; overridable for tests.
+var Output io.Writer = os.Stderr
+
+type entry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component,omitempty"`
+	Message   string `json:"message"`
+}
+
+// A Logger tags its output with a component name, e.g. "listener" or
+// "sender", so multi-subsystem logs can be filtered by source.
+type Logger struct {
+	Component string
+}
+
+// New returns a Logger tagged with the given component name.
+func New(component string) *Logger {
+	return &Logger{Component: component}
+}
+
+func (l *Logger) log(lvl Level, format string, args ...interface{}) {
+	if lvl < GetLevel() {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	now := time.Now()
+
+	if JSON {
+		enc := json.NewEncoder(Output)
+		enc.Encode(entry{Time: now.Format(time.RFC3339), Level: lvl.String(), Component: l.Component, Message: msg})
+	} else if l.Component != "" {
+		fmt.Fprintf(Output, "%s [%s] %s: %s\n", now.Format(time.RFC3339), lvl, l.Component, msg)
+	} else {
+		fmt.Fprintf(Output, "%s [%s] %s\n", now.Format(time.RFC3339), lvl, msg)
+	}
+
+	if lvl == Fatal {
+		os.Exit(1)
+	}
+}
+
+func (l *Logger) Tracef(format string, args ...interface{}) { l.log(Trace, format, args...) }
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(Debug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.log(Info, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.log(Warn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(Error, format, args...) }
+func (l *Logger) Fatalf(format string, args ...interface{}) { l.log(Fatal, format, args...) }
+
+// PanicHandler recovers a panic, logs it at Error level, and re-panics so a
+// supervisor can still see the crash. Defer it at the top of any goroutine
+// that shouldn't silently die:
+//
+//	go func() {
+//	    defer logging.PanicHandler(logger("sender"))
+//	    ...
+//	}()
+func PanicHandler(l *Logger) {
+	if r := recover(); r != nil {
+		l.Errorf("recovered from panic: %v", r)
+		panic(r)
+	}
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func withOutput(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	oldOutput, oldJSON, oldLevel := Output, JSON, GetLevel()
+	Output = &buf
+	t.Cleanup(func() {
+		Output = oldOutput
+		JSON = oldJSON
+		SetLevel(oldLevel)
+	})
+	return &buf
+}
+
+func TestDisabledLevelsAreSkipped(t *testing.T) {
+	buf := withOutput(t)
+	SetLevel(Warn)
+
+	l := New("test")
+	l.Infof("should not appear")
+	l.Warnf("should appear")
+
+	out := buf.String()
+	if strings.Contains(out, "should not appear") {
+		t.Errorf("expected Infof to be suppressed below Warn level, got %q", out)
+	}
+	if !strings.Contains(out, "should appear") {
+		t.Errorf("expected Warnf to be logged, got %q", out)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	buf := withOutput(t)
+	SetLevel(Trace)
+	JSON = true
+
+	New("test").Infof("hello %s", "world")
+
+	var decoded map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected valid JSON line, got %q: %s", buf.String(), err)
+	}
+	if decoded["message"] != "hello world" {
+		t.Errorf("decoded message = %q, want %q", decoded["message"], "hello world")
+	}
+	if decoded["component"] != "test" {
+		t.Errorf("decoded component = %q, want %q", decoded["component"], "test")
+	}
+}
+
+// TestFatalfExitsInJSONMode guards against the JSON branch of log
+// returning before the Fatal exit check runs. It re-execs the test binary
+// since Fatalf calls os.Exit.
+func TestFatalfExitsInJSONMode(t *testing.T) {
+	if os.Getenv("LOGGING_TEST_FATAL") == "1" {
+		JSON = true
+		New("test").Fatalf("boom")
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFatalfExitsInJSONMode")
+	cmd.Env = append(os.Environ(), "LOGGING_TEST_FATAL=1")
+	err := cmd.Run()
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.Success() {
+		t.Fatalf("expected Fatalf in JSON mode to exit nonzero, got err=%v", err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("debug"); err != nil || level != Debug {
+		t.Errorf("ParseLevel(\"debug\") = %v, %v; want Debug, nil", level, err)
+	}
+
+	if _, err := ParseLevel("bogus"); err == nil {
+		t.Errorf("expected an error for an unrecognized level")
+	}
+}
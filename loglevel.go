@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// LogLevelHandler implements `POST /loglevel`, which sets the process-wide
+// log level from a `level` form value (e.g. "debug"), so an operator can
+// turn up verbosity on a running process without restarting it. A plain GET
+// reports the current level.
+func LogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fmt.Fprintf(w, "%s\n", logging.GetLevel())
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "expected GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.FormValue("level")
+	level, err := logging.ParseLevel(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logging.SetLevel(level)
+	fmt.Fprintf(w, "log level set to %s\n", level)
+}
@@ -0,0 +1,16 @@
+package main
+
+import "sync"
+
+// maildirLocks holds one mutex per Maildir, used to serialize sessions
+// (e.g. a POP3 client browsing the mailbox) against anything else that
+// renumbers or removes messages concurrently, like the retention scanner.
+var maildirLocks sync.Map // map[*Maildir]*sync.Mutex
+
+// lockMaildir acquires the lock for m and returns a func to release it.
+func lockMaildir(m *Maildir) func() {
+	lockIface, _ := maildirLocks.LoadOrStore(m, &sync.Mutex{})
+	lock := lockIface.(*sync.Mutex)
+	lock.Lock()
+	return lock.Unlock
+}
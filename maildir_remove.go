@@ -0,0 +1,19 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Remove deletes the named message from the maildir's `cur` directory. It's
+// used by the POP3 server to honor `DELE` on `QUIT`.
+func (m *Maildir) Remove(name string) error {
+	return os.Remove(path.Join(m.Path, "cur", name))
+}
+
+// readRaw returns the full, unparsed contents of a named message, used by
+// the POP3 server for RETR and TOP.
+func (m *Maildir) readRaw(name string) ([]byte, error) {
+	return ioutil.ReadFile(path.Join(m.Path, "cur", name))
+}
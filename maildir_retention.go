@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// Stat reports how many messages are in the maildir, the deposit time of
+// the oldest one, and their total size in bytes. It's used to surface
+// queue depth and age over the HTTP status endpoint.
+func (m *Maildir) Stat() (count int, oldest time.Time, bytes int64, err error) {
+	names, err := m.List()
+	if err != nil {
+		return 0, time.Time{}, 0, err
+	}
+
+	for _, name := range names {
+		full := path.Join(m.Path, "cur", name)
+		info, statErr := os.Stat(full)
+		if statErr != nil {
+			continue
+		}
+
+		count++
+		bytes += info.Size()
+
+		deposited := messageTime(full, name)
+		if oldest.IsZero() || (!deposited.IsZero() && deposited.Before(oldest)) {
+			oldest = deposited
+		}
+	}
+
+	return count, oldest, bytes, nil
+}
+
+// stat returns os.FileInfo for a named message in the maildir's `cur`
+// directory.
+func (m *Maildir) stat(name string) (os.FileInfo, error) {
+	return os.Stat(path.Join(m.Path, "cur", name))
+}
+
+// Purge removes messages older than olderThan, returning the number
+// removed. Age is determined from the `unixTime.pid_N.host` prefix of the
+// filename, falling back to the file's mtime. An optional cap limits how
+// many messages are removed in a single call, so a scanner can spread a
+// large backlog over several scans.
+func (m *Maildir) Purge(olderThan time.Duration, batchMax ...int) (removed int, err error) {
+	limit := 0
+	if len(batchMax) > 0 {
+		limit = batchMax[0]
+	}
+
+	names, err := m.List()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	for _, name := range names {
+		if limit > 0 && removed >= limit {
+			break
+		}
+
+		full := path.Join(m.Path, "cur", name)
+		deposited := messageTime(full, name)
+		if deposited.IsZero() || deposited.After(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(full); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "time"
+
+// OutgoingMessage is anything that can be hand off to an Upstream for
+// delivery: a rendered summary, a raw ReceivedMessage relayed unsummarized
+// (--relay-all), or a courier's view of one restricted to a subset of
+// recipients (see router.go).
+type OutgoingMessage interface {
+	From() string
+	Recipients() []string
+	Contents() string
+}
+
+// Upstream delivers an OutgoingMessage somewhere: a single SMTP server, a
+// Router dispatching by recipient domain, or one of Router's couriers.
+type Upstream interface {
+	Send(msg OutgoingMessage) error
+}
+
+// ReceivedMessage is a single SMTP message accepted by the Listener,
+// before it's been grouped or summarized. It also implements
+// OutgoingMessage, so it can be relayed as-is when --relay-all is set.
+type ReceivedMessage struct {
+	from       string
+	recipients []string
+	data       []byte
+	received   time.Time
+}
+
+// NewReceivedMessage builds a ReceivedMessage, stamping it with the
+// current time so the MessageBuffer can track how long it's been waiting.
+func NewReceivedMessage(from string, recipients []string, data []byte) *ReceivedMessage {
+	return &ReceivedMessage{from: from, recipients: recipients, data: data, received: time.Now()}
+}
+
+func (m *ReceivedMessage) From() string         { return m.from }
+func (m *ReceivedMessage) Recipients() []string { return m.recipients }
+func (m *ReceivedMessage) Contents() string     { return string(m.data) }
+
+// Received reports when the message was accepted.
+func (m *ReceivedMessage) Received() time.Time { return m.received }
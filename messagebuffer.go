@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// BatchSpec controls how many of a flush's ready groups are combined into
+// a single summarized email. A MaxGroups of 0 means no limit -- everything
+// that's ready to flush at once goes out as one summary.
+type BatchSpec struct {
+	MaxGroups int
+}
+
+// GroupSpec decides which messages are collapsed into the same group
+// before they're summarized, so e.g. repeated failures from the same cron
+// job arrive as one summary instead of one email per failure. Fields lists
+// which of "from", "to", and "subject" to key groups by; an empty Fields
+// collapses every message into a single group.
+type GroupSpec struct {
+	Fields []string
+}
+
+func (g GroupSpec) key(msg *ReceivedMessage) string {
+	if len(g.Fields) == 0 {
+		return ""
+	}
+
+	parts := make([]string, len(g.Fields))
+	for i, field := range g.Fields {
+		switch field {
+		case "from":
+			parts[i] = msg.From()
+		case "to":
+			recipients := append([]string{}, msg.Recipients()...)
+			sort.Strings(recipients)
+			parts[i] = strings.Join(recipients, ",")
+		case "subject":
+			parts[i] = subjectOf(msg)
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// subjectOf parses msg's Subject header, returning "" if the message can't
+// be parsed as RFC 822 mail.
+func subjectOf(msg *ReceivedMessage) string {
+	parsed, err := mail.ReadMessage(bytes.NewReader([]byte(msg.Contents())))
+	if err != nil {
+		return ""
+	}
+	return parsed.Header.Get("Subject")
+}
+
+// messageGroup is one bucket of messages waiting to be flushed; every message in
+// it shares the same GroupSpec key.
+type messageGroup struct {
+	key      string
+	messages []*ReceivedMessage
+	first    time.Time
+	last     time.Time
+}
+
+// Summary is a batch of received messages, ready to be rendered into a
+// single outgoing email.
+type Summary struct {
+	Key      string
+	Messages []*ReceivedMessage
+}
+
+// SummaryRenderer turns a Summary into the OutgoingMessage that actually
+// gets relayed upstream.
+type SummaryRenderer interface {
+	Render(summary Summary) OutgoingMessage
+}
+
+// MessageBuffer collects incoming messages into groups and decides when
+// each group is ready to flush: WaitPeriod after its most recent message,
+// or MaxWait after its first, whichever comes first.
+type MessageBuffer struct {
+	WaitPeriod time.Duration
+	MaxWait    time.Duration
+	Batch      BatchSpec
+	Group      GroupSpec
+	From       string
+	Logger     *logging.Logger
+
+	mu     sync.Mutex
+	groups map[string]*messageGroup
+}
+
+// NewMessageBuffer builds a MessageBuffer, tagging its log output with the
+// "buffer" component so it can be told apart from the listener and sender.
+func NewMessageBuffer(waitPeriod, maxWait time.Duration, batch BatchSpec, group GroupSpec, from string) *MessageBuffer {
+	return &MessageBuffer{
+		WaitPeriod: waitPeriod,
+		MaxWait:    maxWait,
+		Batch:      batch,
+		Group:      group,
+		From:       from,
+		Logger:     logger("buffer"),
+		groups:     map[string]*messageGroup{},
+	}
+}
+
+// Add files msg into its group, creating the group if this is its first
+// message.
+func (b *MessageBuffer) Add(msg *ReceivedMessage) {
+	key := b.Group.key(msg)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, ok := b.groups[key]
+	if !ok {
+		g = &messageGroup{key: key, first: time.Now()}
+		b.groups[key] = g
+	}
+	g.messages = append(g.messages, msg)
+	g.last = time.Now()
+}
+
+// Flush removes and returns every group that's ready to be summarized --
+// WaitPeriod has passed since its last message, or MaxWait since its
+// first -- or, if force is true, every group regardless of age. Ready
+// groups are combined Batch.MaxGroups at a time (0 means no limit) into
+// one Summary each.
+func (b *MessageBuffer) Flush(force bool) []Summary {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	var ready []*messageGroup
+	for key, g := range b.groups {
+		if force || now.Sub(g.last) >= b.WaitPeriod || now.Sub(g.first) >= b.MaxWait {
+			ready = append(ready, g)
+			delete(b.groups, key)
+		}
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+
+	sort.Slice(ready, func(i, j int) bool { return ready[i].first.Before(ready[j].first) })
+
+	maxGroups := b.Batch.MaxGroups
+	if maxGroups <= 0 {
+		maxGroups = len(ready)
+	}
+
+	var summaries []Summary
+	for i := 0; i < len(ready); i += maxGroups {
+		end := i + maxGroups
+		if end > len(ready) {
+			end = len(ready)
+		}
+
+		var messages []*ReceivedMessage
+		var keys []string
+		for _, g := range ready[i:end] {
+			messages = append(messages, g.messages...)
+			keys = append(keys, g.key)
+		}
+		summaries = append(summaries, Summary{Key: strings.Join(keys, ","), Messages: messages})
+	}
+
+	b.Logger.Infof("flushed %d group(s) into %d summary/summaries", len(ready), len(summaries))
+	return summaries
+}
+
+// Pending reports how many groups are currently buffered, for the HTTP
+// status endpoint.
+func (b *MessageBuffer) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.groups)
+}
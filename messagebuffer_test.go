@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageBufferGroupsBySender(t *testing.T) {
+	b := NewMessageBuffer(time.Hour, time.Hour, BatchSpec{}, GroupSpec{Fields: []string{"from"}}, "summaries@example.com")
+
+	b.Add(NewReceivedMessage("job-a@example.com", []string{"ops@example.com"}, []byte("cron failed")))
+	b.Add(NewReceivedMessage("job-a@example.com", []string{"ops@example.com"}, []byte("cron failed again")))
+	b.Add(NewReceivedMessage("job-b@example.com", []string{"ops@example.com"}, []byte("other job failed")))
+
+	if pending := b.Pending(); pending != 2 {
+		t.Fatalf("Pending() = %d, want 2 groups", pending)
+	}
+
+	summaries := b.Flush(true)
+	if len(summaries) != 2 {
+		t.Fatalf("Flush(true) returned %d summaries, want 2", len(summaries))
+	}
+
+	total := 0
+	for _, s := range summaries {
+		total += len(s.Messages)
+	}
+	if total != 3 {
+		t.Errorf("summaries covered %d messages total, want 3", total)
+	}
+	if b.Pending() != 0 {
+		t.Errorf("Pending() after Flush(true) = %d, want 0", b.Pending())
+	}
+}
+
+func TestMessageBufferFlushWithoutForceRespectsWaitPeriod(t *testing.T) {
+	b := NewMessageBuffer(time.Hour, time.Hour, BatchSpec{}, GroupSpec{}, "")
+	b.Add(NewReceivedMessage("a@example.com", []string{"ops@example.com"}, []byte("x")))
+
+	if summaries := b.Flush(false); len(summaries) != 0 {
+		t.Errorf("Flush(false) = %d summaries, want 0 before WaitPeriod elapses", len(summaries))
+	}
+
+	b.WaitPeriod = 0
+	if summaries := b.Flush(false); len(summaries) != 1 {
+		t.Errorf("Flush(false) = %d summaries, want 1 once WaitPeriod is satisfied", len(summaries))
+	}
+}
+
+func TestMessageBufferBatchSpecLimitsGroupsPerSummary(t *testing.T) {
+	b := NewMessageBuffer(time.Hour, time.Hour, BatchSpec{MaxGroups: 1}, GroupSpec{Fields: []string{"from"}}, "")
+
+	b.Add(NewReceivedMessage("a@example.com", []string{"ops@example.com"}, []byte("a")))
+	b.Add(NewReceivedMessage("b@example.com", []string{"ops@example.com"}, []byte("b")))
+
+	summaries := b.Flush(true)
+	if len(summaries) != 2 {
+		t.Fatalf("Flush(true) with MaxGroups=1 returned %d summaries, want 2", len(summaries))
+	}
+	for _, s := range summaries {
+		if len(s.Messages) != 1 {
+			t.Errorf("summary has %d messages, want 1 with MaxGroups=1", len(s.Messages))
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// POP3Server serves a read-only (plus delete) view of a maildir over POP3
+// (RFC 1939), so operators can pull failed alerts with any mail client
+// instead of shelling in and reading files by hand.
+type POP3Server struct {
+	Bind    string
+	Maildir *Maildir
+	Auth    Auth
+	Logger  *logging.Logger
+}
+
+// NewPOP3Server builds a server for maildir, bound to bind, sharing
+// credentials with the SMTP listener's Auth.
+func NewPOP3Server(bind string, maildir *Maildir, auth Auth) *POP3Server {
+	return &POP3Server{Bind: bind, Maildir: maildir, Auth: auth, Logger: logger("pop3")}
+}
+
+// ListenAndServe binds Bind and accepts connections forever. It returns only
+// if binding fails; accept errors are logged and the loop carries on.
+func (s *POP3Server) ListenAndServe() error {
+	defer logging.PanicHandler(s.Logger)
+
+	listener, err := net.Listen("tcp", s.Bind)
+	if err != nil {
+		return fmt.Errorf("couldn't bind pop3 listener: %s", err)
+	}
+	s.Logger.Infof("listening on %s", s.Bind)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.Logger.Errorf("accept failed: %s", err)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// mailbox is a snapshot of a maildir's messages as of the moment a POP3
+// session started, numbered 1..N per RFC 1939. Holding a lock on the
+// underlying Maildir for the session's duration keeps a concurrent scanner
+// (e.g. the retention janitor) from renumbering or removing messages out
+// from under the client.
+type mailbox struct {
+	maildir *Maildir
+	names   []string
+	deleted map[int]bool
+
+	unlock     func()
+	unlockOnce sync.Once
+}
+
+func newMailbox(m *Maildir) (*mailbox, error) {
+	unlock := lockMaildir(m)
+
+	names, err := m.List()
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return &mailbox{maildir: m, names: names, deleted: map[int]bool{}, unlock: unlock}, nil
+}
+
+// release unlocks the mailbox exactly once, whether called after a clean
+// QUIT or because the client disconnected without one. Without this, a
+// client that vanishes mid-session (timeout, reset, killed process) would
+// leave the maildir permanently locked for every future session.
+func (mb *mailbox) release() {
+	mb.unlockOnce.Do(mb.unlock)
+}
+
+// GetMessage returns the name of message id (1-based). id == 0 or an id
+// beyond the mailbox's length is an error rather than a panic, since it
+// comes straight off the wire from a POP3 client.
+func (mb *mailbox) GetMessage(id int) (string, error) {
+	if id < 1 || id > len(mb.names) {
+		return "", fmt.Errorf("no such message %d", id)
+	}
+	return mb.names[id-1], nil
+}
+
+func (mb *mailbox) isDeleted(id int) bool {
+	return mb.deleted[id]
+}
+
+// stat returns the count and total size, in bytes, of messages not marked
+// for deletion.
+func (mb *mailbox) stat() (count int, bytes int64) {
+	for i, name := range mb.names {
+		id := i + 1
+		if mb.isDeleted(id) {
+			continue
+		}
+		if info, err := mb.maildir.stat(name); err == nil {
+			count++
+			bytes += info.Size()
+		}
+	}
+	return count, bytes
+}
+
+// finish removes messages marked for deletion. It's called on QUIT; lock
+// release is handled separately by release(), which runs whether or not
+// the client quit cleanly.
+func (mb *mailbox) finish() error {
+	for id, marked := range mb.deleted {
+		if !marked {
+			continue
+		}
+		name, err := mb.GetMessage(id)
+		if err != nil {
+			continue
+		}
+		if err := mb.maildir.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type pop3Session struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	server  *POP3Server
+	user    string
+	mailbox *mailbox
+}
+
+func (s *POP3Server) handle(conn net.Conn) {
+	defer logging.PanicHandler(s.Logger)
+	defer conn.Close()
+
+	session := &pop3Session{conn: conn, reader: bufio.NewReader(conn), server: s}
+	defer func() {
+		if session.mailbox != nil {
+			session.mailbox.release()
+		}
+	}()
+
+	session.respondOK("failmail POP3 ready")
+
+	for {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		parts := strings.Fields(strings.TrimSpace(line))
+		if len(parts) == 0 {
+			continue
+		}
+
+		cmd := strings.ToUpper(parts[0])
+		args := parts[1:]
+
+		if cmd == "QUIT" {
+			session.quit()
+			return
+		}
+
+		session.dispatch(cmd, args)
+	}
+}
+
+func (session *pop3Session) dispatch(cmd string, args []string) {
+	switch cmd {
+	case "USER":
+		session.user = strings.Join(args, " ")
+		session.respondOK("user accepted")
+	case "PASS":
+		session.handlePass(strings.Join(args, " "))
+	case "STAT":
+		session.handleStat()
+	case "LIST":
+		session.handleList(args)
+	case "RETR":
+		session.handleRetr(args)
+	case "TOP":
+		session.handleTop(args)
+	case "DELE":
+		session.handleDele(args)
+	case "RSET":
+		session.handleRset()
+	default:
+		session.respondErr("unknown command")
+	}
+}
+
+func (session *pop3Session) handlePass(pass string) {
+	if session.server.Auth.required() && !session.server.Auth.check(session.user, pass) {
+		session.respondErr("authentication failed")
+		return
+	}
+
+	mb, err := newMailbox(session.server.Maildir)
+	if err != nil {
+		session.respondErr(fmt.Sprintf("couldn't open mailbox: %s", err))
+		return
+	}
+	session.mailbox = mb
+	session.respondOK(fmt.Sprintf("%s's mailbox ready", session.user))
+}
+
+func (session *pop3Session) requireMailbox() bool {
+	if session.mailbox == nil {
+		session.respondErr("not authenticated")
+		return false
+	}
+	return true
+}
+
+func (session *pop3Session) handleStat() {
+	if !session.requireMailbox() {
+		return
+	}
+	count, bytes := session.mailbox.stat()
+	session.respondOK(fmt.Sprintf("%d %d", count, bytes))
+}
+
+func (session *pop3Session) handleList(args []string) {
+	if !session.requireMailbox() {
+		return
+	}
+
+	if len(args) == 1 {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			session.respondErr("invalid message number")
+			return
+		}
+		name, err := session.mailbox.GetMessage(id)
+		if err != nil || session.mailbox.isDeleted(id) {
+			session.respondErr("no such message")
+			return
+		}
+		info, err := session.mailbox.maildir.stat(name)
+		if err != nil {
+			session.respondErr("no such message")
+			return
+		}
+		session.respondOK(fmt.Sprintf("%d %d", id, info.Size()))
+		return
+	}
+
+	session.respondOK("scan listing follows")
+	for i, name := range session.mailbox.names {
+		id := i + 1
+		if session.mailbox.isDeleted(id) {
+			continue
+		}
+		if info, err := session.mailbox.maildir.stat(name); err == nil {
+			fmt.Fprintf(session.conn, "%d %d\r\n", id, info.Size())
+		}
+	}
+	fmt.Fprint(session.conn, ".\r\n")
+}
+
+func (session *pop3Session) handleRetr(args []string) {
+	if !session.requireMailbox() || len(args) != 1 {
+		session.respondErr("usage: RETR msg")
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		session.respondErr("invalid message number")
+		return
+	}
+
+	name, err := session.mailbox.GetMessage(id)
+	if err != nil || session.mailbox.isDeleted(id) {
+		session.respondErr("no such message")
+		return
+	}
+
+	raw, err := session.mailbox.maildir.readRaw(name)
+	if err != nil {
+		session.respondErr(fmt.Sprintf("couldn't read message: %s", err))
+		return
+	}
+
+	session.respondOK("message follows")
+	writeDotStuffed(session.conn, string(raw))
+}
+
+func (session *pop3Session) handleTop(args []string) {
+	if !session.requireMailbox() || len(args) != 2 {
+		session.respondErr("usage: TOP msg n")
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		session.respondErr("invalid message number")
+		return
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		session.respondErr("invalid line count")
+		return
+	}
+
+	name, err := session.mailbox.GetMessage(id)
+	if err != nil || session.mailbox.isDeleted(id) {
+		session.respondErr("no such message")
+		return
+	}
+
+	raw, err := session.mailbox.maildir.readRaw(name)
+	if err != nil {
+		session.respondErr(fmt.Sprintf("couldn't read message: %s", err))
+		return
+	}
+
+	session.respondOK("top of message follows")
+	writeDotStuffed(session.conn, topLines(string(raw), n))
+}
+
+// topLines returns all header lines plus the first n lines of the body, as
+// used by POP3's TOP command.
+func topLines(raw string, n int) string {
+	headerEnd := strings.Index(raw, "\r\n\r\n")
+	sep := "\r\n\r\n"
+	if headerEnd < 0 {
+		headerEnd = strings.Index(raw, "\n\n")
+		sep = "\n\n"
+		if headerEnd < 0 {
+			return raw
+		}
+	}
+
+	header := raw[:headerEnd+len(sep)]
+	body := strings.Split(raw[headerEnd+len(sep):], "\n")
+	if n > len(body) {
+		n = len(body)
+	}
+	return header + strings.Join(body[:n], "\n")
+}
+
+func (session *pop3Session) handleDele(args []string) {
+	if !session.requireMailbox() || len(args) != 1 {
+		session.respondErr("usage: DELE msg")
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		session.respondErr("invalid message number")
+		return
+	}
+
+	if _, err := session.mailbox.GetMessage(id); err != nil {
+		session.respondErr("no such message")
+		return
+	}
+
+	session.mailbox.deleted[id] = true
+	session.respondOK(fmt.Sprintf("message %d deleted", id))
+}
+
+func (session *pop3Session) handleRset() {
+	if !session.requireMailbox() {
+		return
+	}
+	session.mailbox.deleted = map[int]bool{}
+	session.respondOK("mailbox reset")
+}
+
+func (session *pop3Session) quit() {
+	if session.mailbox != nil {
+		if err := session.mailbox.finish(); err != nil {
+			session.respondErr(fmt.Sprintf("couldn't remove deleted messages: %s", err))
+			return
+		}
+	}
+	session.respondOK("failmail POP3 signing off")
+}
+
+func (session *pop3Session) respondOK(msg string) {
+	fmt.Fprintf(session.conn, "+OK %s\r\n", msg)
+}
+
+func (session *pop3Session) respondErr(msg string) {
+	fmt.Fprintf(session.conn, "-ERR %s\r\n", msg)
+}
+
+// writeDotStuffed writes msg per RFC 1939's byte-stuffing rule (a leading
+// "." on any line is doubled) followed by the terminating "." line.
+func writeDotStuffed(w io.Writer, msg string) {
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		fmt.Fprintf(w, "%s\r\n", line)
+	}
+	fmt.Fprint(w, ".\r\n")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetMessageRejectsOutOfRangeIds(t *testing.T) {
+	mb := &mailbox{names: []string{"a", "b"}, deleted: map[int]bool{}}
+
+	cases := []int{-1, 0, 3, 100}
+	for _, id := range cases {
+		if _, err := mb.GetMessage(id); err == nil {
+			t.Errorf("GetMessage(%d) = nil error, want an error", id)
+		}
+	}
+
+	if name, err := mb.GetMessage(1); err != nil || name != "a" {
+		t.Errorf("GetMessage(1) = %q, %v; want \"a\", nil", name, err)
+	}
+}
+
+func TestMailboxReleaseIsIdempotent(t *testing.T) {
+	calls := 0
+	mb := &mailbox{unlock: func() { calls++ }}
+
+	mb.release()
+	mb.release()
+
+	if calls != 1 {
+		t.Errorf("unlock called %d times, want exactly 1", calls)
+	}
+}
+
+func TestNewMailboxLocksAndUnlockReleases(t *testing.T) {
+	m, cleanup := makeTestMaildir(t)
+	defer cleanup()
+
+	mb, err := newMailbox(m)
+	if err != nil {
+		t.Fatalf("unexpected error from newMailbox: %s", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		defer lockMaildir(m)()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatalf("expected the maildir to still be locked by the open mailbox")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	mb.release()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the maildir to be lockable after release")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// RecipientCounts are the running totals for how the RecipientFilter has
+// disposed of RCPT TO addresses, exposed over the HTTP status endpoint.
+type RecipientCounts struct {
+	Accepted  int64
+	Discarded int64
+	Tarpitted int64
+}
+
+// RecipientFilter decides whether an incoming message's recipient is one
+// failmail is configured to summarize. Unlisted recipients are silently
+// discarded (and optionally tarpitted), turning failmail into a catch-all
+// at its host that doesn't relay spam sent to random addresses.
+type RecipientFilter struct {
+	TarpitDelay time.Duration
+	Logger      *logging.Logger
+
+	mu        sync.RWMutex
+	allowed   map[string]bool
+	accepted  int64
+	discarded int64
+	tarpitted int64
+}
+
+// NewRecipientFilter builds a filter with no configured recipients; every
+// address is rejected until Reload is called with a path. main only builds
+// one when --recipients is set; otherwise the Listener's Recipients is nil
+// and no filtering happens.
+func NewRecipientFilter(tarpitDelay time.Duration) *RecipientFilter {
+	return &RecipientFilter{TarpitDelay: tarpitDelay, Logger: logger("recipients"), allowed: map[string]bool{}}
+}
+
+// Reload re-reads the recipients file, one address per line, blank lines
+// and lines starting with `#` ignored, and atomically swaps it in. It's
+// called at startup and again on SIGHUP via the Reloader.
+func (f *RecipientFilter) Reload(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open recipients file %s: %s", path, err)
+	}
+	defer file.Close()
+
+	allowed := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		allowed[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("couldn't read recipients file %s: %s", path, err)
+	}
+
+	f.mu.Lock()
+	f.allowed = allowed
+	f.mu.Unlock()
+
+	f.Logger.Infof("loaded %d recipients from %s", len(allowed), path)
+	return nil
+}
+
+// Accepts reports whether addr is in the allowlist.
+func (f *RecipientFilter) Accepts(addr string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.allowed[strings.ToLower(addr)]
+}
+
+// Accept records that a RCPT TO address was found on the allowlist and
+// added to the transaction. It's counted once per address, at RCPT time.
+func (f *RecipientFilter) Accept() {
+	atomic.AddInt64(&f.accepted, 1)
+}
+
+// Discard records that an unlisted RCPT TO address was accepted at the
+// protocol level and left out of the transaction. It's counted once per
+// address, at RCPT time.
+func (f *RecipientFilter) Discard() {
+	atomic.AddInt64(&f.discarded, 1)
+}
+
+// Tarpit records that a discarded message's response was delayed, and
+// sleeps for TarpitDelay if one is configured.
+func (f *RecipientFilter) Tarpit() {
+	atomic.AddInt64(&f.tarpitted, 1)
+	if f.TarpitDelay > 0 {
+		time.Sleep(f.TarpitDelay)
+	}
+}
+
+// Counts returns a snapshot of the accepted/discarded/tarpitted counters.
+func (f *RecipientFilter) Counts() RecipientCounts {
+	return RecipientCounts{
+		Accepted:  atomic.LoadInt64(&f.accepted),
+		Discarded: atomic.LoadInt64(&f.discarded),
+		Tarpitted: atomic.LoadInt64(&f.tarpitted),
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func writeRecipientsFile(t *testing.T, lines ...string) (string, func()) {
+	tmp, err := ioutil.TempDir("", "recipients")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %s", err)
+	}
+
+	file := path.Join(tmp, "recipients")
+	contents := ""
+	for _, line := range lines {
+		contents += line + "\n"
+	}
+	if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatalf("couldn't write recipients file: %s", err)
+	}
+
+	return file, func() { os.RemoveAll(tmp) }
+}
+
+func TestRecipientFilterAccepts(t *testing.T) {
+	file, cleanup := writeRecipientsFile(t, "ops@example.com", "# a comment", "", "alerts@example.com")
+	defer cleanup()
+
+	f := NewRecipientFilter(0)
+	if err := f.Reload(file); err != nil {
+		t.Fatalf("unexpected error from Reload: %s", err)
+	}
+
+	if !f.Accepts("ops@example.com") {
+		t.Errorf("expected ops@example.com to be accepted")
+	}
+	if !f.Accepts("OPS@EXAMPLE.COM") {
+		t.Errorf("expected recipient matching to be case-insensitive")
+	}
+	if f.Accepts("random@example.com") {
+		t.Errorf("expected an unlisted address to be rejected")
+	}
+}
+
+func TestRecipientFilterCounters(t *testing.T) {
+	file, cleanup := writeRecipientsFile(t, "ops@example.com")
+	defer cleanup()
+
+	f := NewRecipientFilter(0)
+	if err := f.Reload(file); err != nil {
+		t.Fatalf("unexpected error from Reload: %s", err)
+	}
+
+	f.Accept()
+	f.Discard()
+	f.Tarpit()
+
+	counts := f.Counts()
+	if counts.Accepted != 1 || counts.Discarded != 1 || counts.Tarpitted != 1 {
+		t.Errorf("unexpected counts: %+v", counts)
+	}
+}
+
+func TestRecipientFilterTarpitDelay(t *testing.T) {
+	f := NewRecipientFilter(20 * time.Millisecond)
+
+	start := time.Now()
+	f.Tarpit()
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected Tarpit to sleep at least 20ms, took %s", elapsed)
+	}
+}
+
+func TestRecipientFilterReload(t *testing.T) {
+	file, cleanup := writeRecipientsFile(t, "ops@example.com")
+	defer cleanup()
+
+	f := NewRecipientFilter(0)
+	if err := f.Reload(file); err != nil {
+		t.Fatalf("unexpected error from Reload: %s", err)
+	}
+	if f.Accepts("alerts@example.com") {
+		t.Fatalf("alerts@example.com shouldn't be accepted yet")
+	}
+
+	if err := ioutil.WriteFile(file, []byte("alerts@example.com\n"), 0644); err != nil {
+		t.Fatalf("couldn't rewrite recipients file: %s", err)
+	}
+	if err := f.Reload(file); err != nil {
+		t.Fatalf("unexpected error from second Reload: %s", err)
+	}
+
+	if f.Accepts("ops@example.com") {
+		t.Errorf("expected ops@example.com to no longer be accepted after reload")
+	}
+	if !f.Accepts("alerts@example.com") {
+		t.Errorf("expected alerts@example.com to be accepted after reload")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// RetentionStats summarizes the most recent retention scan of a maildir, so
+// operators can see how many failed messages are queued and how old they
+// are without shelling in.
+type RetentionStats struct {
+	mu sync.Mutex
+
+	LastRun time.Time
+	Count   int
+	Oldest  time.Time
+	Bytes   int64
+	Removed int
+}
+
+func (s *RetentionStats) set(count int, oldest time.Time, bytes int64, removed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastRun = time.Now()
+	s.Count = count
+	s.Oldest = oldest
+	s.Bytes = bytes
+	s.Removed = removed
+}
+
+// Snapshot returns a copy of the stats safe for concurrent reads, e.g. from
+// an HTTP handler.
+func (s *RetentionStats) Snapshot() RetentionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RetentionStats{LastRun: s.LastRun, Count: s.Count, Oldest: s.Oldest, Bytes: s.Bytes, Removed: s.Removed}
+}
+
+// RetentionScanner periodically purges messages older than MaxAge from a
+// maildir, so a spool doesn't grow unbounded while an upstream is down.
+type RetentionScanner struct {
+	Maildir  *Maildir
+	MaxAge   time.Duration
+	BatchMax int
+	MinSleep time.Duration
+	Stats    *RetentionStats
+	Logger   *logging.Logger
+}
+
+// NewRetentionScanner builds a scanner for maildir, purging messages older
+// than maxAge. A batchMax of 0 means no per-scan cap.
+func NewRetentionScanner(maildir *Maildir, maxAge time.Duration, batchMax int, minSleep time.Duration) *RetentionScanner {
+	return &RetentionScanner{
+		Maildir:  maildir,
+		MaxAge:   maxAge,
+		BatchMax: batchMax,
+		MinSleep: minSleep,
+		Stats:    &RetentionStats{},
+		Logger:   logger("retention"),
+	}
+}
+
+// Run scans on a timer until it receives a termination request on done.
+func (r *RetentionScanner) Run(done <-chan TerminationRequest) {
+	defer logging.PanicHandler(r.Logger)
+
+	if r.MaxAge <= 0 {
+		return
+	}
+
+	sleep := r.MinSleep
+	if sleep <= 0 {
+		sleep = 1 * time.Minute
+	}
+
+	tick := time.Tick(sleep)
+	for {
+		select {
+		case <-tick:
+			r.scan()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (r *RetentionScanner) scan() {
+	// Hold the same lock a POP3 session holds for its duration, so this
+	// scan can't delete or renumber a message a client currently has
+	// listed.
+	defer lockMaildir(r.Maildir)()
+
+	removed, purgeErr := r.Maildir.Purge(r.MaxAge, r.BatchMax)
+	if purgeErr != nil {
+		r.Logger.Errorf("couldn't purge maildir %s: %s", r.Maildir.Path, purgeErr)
+	}
+
+	// Stat after purging, so RetentionStats reflects what's actually left
+	// in the maildir rather than the pre-purge count.
+	count, oldest, bytes, err := r.Maildir.Stat()
+	if err != nil {
+		r.Logger.Errorf("couldn't stat maildir %s: %s", r.Maildir.Path, err)
+		return
+	}
+
+	if removed > 0 || purgeErr != nil {
+		r.Logger.Infof("scanned %s: %d messages queued, %d removed", r.Maildir.Path, count, removed)
+	} else {
+		r.Logger.Debugf("scanned %s: %d messages queued, none old enough to remove", r.Maildir.Path, count)
+	}
+
+	r.Stats.set(count, oldest, bytes, removed)
+}
+
+// messageTime returns the time a maildir entry was deposited, parsed from
+// its `unixTime.pid_N.host` prefix. If the prefix can't be parsed, it falls
+// back to the file's mtime.
+func messageTime(path, name string) time.Time {
+	prefix := name
+	if i := strings.IndexByte(prefix, '.'); i >= 0 {
+		prefix = prefix[:i]
+	}
+
+	if secs, err := strconv.ParseInt(prefix, 10, 64); err == nil {
+		return time.Unix(secs, 0)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageTimeFromPrefix(t *testing.T) {
+	got := messageTime("/does-not-exist", "1393650000.1000_1.test:2,S")
+	want := time.Unix(1393650000, 0)
+	if !got.Equal(want) {
+		t.Errorf("messageTime() = %v, want %v", got, want)
+	}
+}
+
+func TestPurgeRemovesOnlyOldMessages(t *testing.T) {
+	m, cleanup := makeTestMaildir(t)
+	defer cleanup()
+
+	defer patchHost("test", nil)()
+	defer patchPid(1000)()
+
+	defer patchTime(time.Now().Add(-2 * time.Hour))()
+	if _, err := m.Write([]byte("old message")); err != nil {
+		t.Fatalf("couldn't write old message: %s", err)
+	}
+
+	defer patchTime(time.Now())()
+	if _, err := m.Write([]byte("new message")); err != nil {
+		t.Fatalf("couldn't write new message: %s", err)
+	}
+
+	removed, err := m.Purge(1 * time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error from Purge: %s", err)
+	}
+	if removed != 1 {
+		t.Errorf("Purge() removed %d messages, want 1", removed)
+	}
+
+	names, err := m.List()
+	if err != nil {
+		t.Fatalf("unexpected error from List: %s", err)
+	}
+	if len(names) != 1 {
+		t.Errorf("expected 1 message left, found %d", len(names))
+	}
+}
+
+func TestPurgeRespectsBatchMax(t *testing.T) {
+	m, cleanup := makeTestMaildir(t)
+	defer cleanup()
+
+	defer patchHost("test", nil)()
+	defer patchPid(1000)()
+	defer patchTime(time.Now().Add(-2 * time.Hour))()
+
+	for i := 0; i < 3; i++ {
+		if _, err := m.Write([]byte("old message")); err != nil {
+			t.Fatalf("couldn't write message: %s", err)
+		}
+	}
+
+	removed, err := m.Purge(1*time.Hour, 2)
+	if err != nil {
+		t.Fatalf("unexpected error from Purge: %s", err)
+	}
+	if removed != 2 {
+		t.Errorf("Purge() with batchMax=2 removed %d messages, want 2", removed)
+	}
+}
+
+func TestScanStatsReflectPostPurgeState(t *testing.T) {
+	m, cleanup := makeTestMaildir(t)
+	defer cleanup()
+
+	defer patchHost("test", nil)()
+	defer patchPid(1000)()
+	defer patchTime(time.Now().Add(-2 * time.Hour))()
+
+	if _, err := m.Write([]byte("old message")); err != nil {
+		t.Fatalf("couldn't write old message: %s", err)
+	}
+
+	scanner := NewRetentionScanner(m, 1*time.Hour, 0, time.Minute)
+	scanner.scan()
+
+	stats := scanner.Stats.Snapshot()
+	if stats.Count != 0 {
+		t.Errorf("Stats.Count = %d, want 0 after purging the only message", stats.Count)
+	}
+	if stats.Removed != 1 {
+		t.Errorf("Stats.Removed = %d, want 1", stats.Removed)
+	}
+}
+
+func TestStatReportsCountAndBytes(t *testing.T) {
+	m, cleanup := makeTestMaildir(t)
+	defer cleanup()
+
+	defer patchHost("test", nil)()
+	defer patchPid(1000)()
+	defer patchTime(time.Unix(1393650000, 0))()
+
+	if _, err := m.Write([]byte("hello")); err != nil {
+		t.Fatalf("couldn't write message: %s", err)
+	}
+
+	count, oldest, bytes, err := m.Stat()
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("Stat() count = %d, want 1", count)
+	}
+	if bytes != int64(len("hello")) {
+		t.Errorf("Stat() bytes = %d, want %d", bytes, len("hello"))
+	}
+	if !oldest.Equal(time.Unix(1393650000, 0)) {
+		t.Errorf("Stat() oldest = %v, want %v", oldest, time.Unix(1393650000, 0))
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// Router dispatches each OutgoingMessage to one of several couriers based
+// on the recipient's domain, so failmail can act as a small relay instead
+// of requiring a single hard-coded upstream. It implements Upstream, so it
+// can be used anywhere a single Upstream was before.
+type Router struct {
+	Routes  map[string]Upstream
+	Default Upstream
+	Logger  *logging.Logger
+}
+
+// NewRouter builds a Router with no configured routes; every message falls
+// through to def.
+func NewRouter(def Upstream) *Router {
+	return &Router{Routes: map[string]Upstream{}, Default: def, Logger: logger("router")}
+}
+
+// Send delivers msg via the courier configured for its recipients'
+// domains, falling back to the default courier for any domain without an
+// explicit route. If a message has recipients in more than one domain, each
+// matched courier only sees the recipients that actually route to it,
+// never the full, unfiltered recipient list. Every matched courier is
+// tried even if an earlier one fails, and the returned error (if any) is a
+// *PartialSendError naming only the recipients that didn't go out, so a
+// caller persisting the message for retry doesn't also resend it to
+// recipients that were already delivered.
+func (r *Router) Send(msg OutgoingMessage) error {
+	grouped := map[Upstream][]string{}
+	for domain, recipients := range groupRecipientsByDomain(msg) {
+		courier, ok := r.Routes[domain]
+		if !ok {
+			courier = r.Default
+		}
+		grouped[courier] = append(grouped[courier], recipients...)
+	}
+
+	if len(grouped) == 0 {
+		grouped[r.Default] = nil
+	}
+
+	var failed []string
+	var lastErr error
+	for courier, recipients := range grouped {
+		if err := courier.Send(restrictRecipients(msg, recipients)); err != nil {
+			r.Logger.Warnf("courier failed for %d recipient(s): %s", len(recipients), err)
+			failed = append(failed, recipients...)
+			lastErr = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PartialSendError{Recipients: failed, Err: lastErr}
+	}
+	return nil
+}
+
+// PartialSendError indicates that a Send covering several recipients only
+// partially failed: Recipients lists just the addresses that weren't
+// delivered, so code persisting the message for retry (e.g. sendUpstream)
+// can limit itself to those recipients instead of resending to ones that
+// already succeeded.
+type PartialSendError struct {
+	Recipients []string
+	Err        error
+}
+
+func (e *PartialSendError) Error() string {
+	return fmt.Sprintf("delivery failed for %d recipient(s): %s", len(e.Recipients), e.Err)
+}
+
+func (e *PartialSendError) Unwrap() error { return e.Err }
+
+// domainOf returns the domain part of an email address, or "" if addr has
+// no "@".
+func domainOf(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return strings.ToLower(addr[i+1:])
+	}
+	return ""
+}
+
+// groupRecipientsByDomain partitions msg's recipients by domain.
+func groupRecipientsByDomain(msg OutgoingMessage) map[string][]string {
+	groups := map[string][]string{}
+	for _, addr := range msg.Recipients() {
+		domain := domainOf(addr)
+		groups[domain] = append(groups[domain], addr)
+	}
+	return groups
+}
+
+// restrictedMessage wraps an OutgoingMessage to limit Recipients() to a
+// subset, so a courier matched by one domain never sees recipients that
+// belong to a different route.
+type restrictedMessage struct {
+	OutgoingMessage
+	recipients []string
+}
+
+func (m *restrictedMessage) Recipients() []string { return m.recipients }
+
+func restrictRecipients(msg OutgoingMessage, recipients []string) OutgoingMessage {
+	return &restrictedMessage{OutgoingMessage: msg, recipients: recipients}
+}
+
+// RoutesFromFile parses a routes file, one route per line in the form
+// `domain=smtp://host:port`, `domain=mda:/path/to/binary arg1 arg2`, or
+// `domain=maildir:/path`, and builds a Router with def as the fallback
+// courier for domains it doesn't match.
+func RoutesFromFile(path string, def Upstream) (*Router, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open routes file %s: %s", path, err)
+	}
+	defer file.Close()
+
+	router := NewRouter(def)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed route %q", line)
+		}
+
+		domain, target := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		courier, err := courierFromTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("bad route for %s: %s", domain, err)
+		}
+		router.Routes[domain] = courier
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read routes file %s: %s", path, err)
+	}
+
+	router.Logger.Infof("loaded %d routes from %s", len(router.Routes), path)
+	return router, nil
+}
+
+func courierFromTarget(target string) (Upstream, error) {
+	switch {
+	case strings.HasPrefix(target, "smtp://"):
+		return &SMTPCourier{Addr: strings.TrimPrefix(target, "smtp://")}, nil
+	case strings.HasPrefix(target, "mda:"):
+		fields := strings.Fields(strings.TrimPrefix(target, "mda:"))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("mda route has no command")
+		}
+		return &MDACourier{Command: fields[0], Args: fields[1:]}, nil
+	case strings.HasPrefix(target, "maildir:"):
+		path := strings.TrimPrefix(target, "maildir:")
+		maildir := &Maildir{Path: path}
+		if err := maildir.Create(); err != nil {
+			return nil, fmt.Errorf("couldn't create maildir %s: %s", path, err)
+		}
+		return &MaildirCourier{Maildir: maildir}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized route target %q", target)
+	}
+}
+
+// SMTPCourier relays a message to a fixed upstream SMTP server, the same
+// behavior failmail has always had for its single configured upstream.
+type SMTPCourier struct {
+	Addr string
+}
+
+func (c *SMTPCourier) Send(msg OutgoingMessage) error {
+	return smtp.SendMail(c.Addr, nil, msg.From(), msg.Recipients(), []byte(msg.Contents()))
+}
+
+// MDACourier hands a message's contents to a local binary over stdin, the
+// way sendmail-compatible MDAs expect to be invoked, and treats a nonzero
+// exit status as a delivery failure.
+type MDACourier struct {
+	Command string
+	Args    []string
+}
+
+func (c *MDACourier) Send(msg OutgoingMessage) error {
+	cmd := exec.Command(c.Command, c.Args...)
+	cmd.Stdin = strings.NewReader(msg.Contents())
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mda %s exited with error: %s: %s", c.Command, err, output)
+	}
+	return nil
+}
+
+// MaildirCourier writes a message's contents into a maildir instead of
+// sending it anywhere, useful for testing routes or archiving mail for a
+// domain failmail shouldn't relay.
+type MaildirCourier struct {
+	Maildir *Maildir
+}
+
+func (c *MaildirCourier) Send(msg OutgoingMessage) error {
+	_, err := c.Maildir.Write([]byte(msg.Contents()))
+	return err
+}
+
+// MXCourier is the implicit default route: it looks up MX records for the
+// recipient's domain and relays via STARTTLS to the highest-priority host
+// that accepts the connection, so failmail can act as a small relay for
+// domains with no explicit route.
+type MXCourier struct {
+	Logger *logging.Logger
+}
+
+// NewMXCourier builds the implicit default courier.
+func NewMXCourier() *MXCourier {
+	return &MXCourier{Logger: logger("mx-courier")}
+}
+
+// Send tries every domain in msg's recipients, even if an earlier domain
+// failed, and returns a *PartialSendError naming only the recipients in
+// domains that couldn't be delivered.
+func (c *MXCourier) Send(msg OutgoingMessage) error {
+	var failed []string
+	var lastErr error
+
+	for domain, recipients := range groupRecipientsByDomain(msg) {
+		mxs, err := net.LookupMX(domain)
+		if err != nil || len(mxs) == 0 {
+			failed = append(failed, recipients...)
+			lastErr = fmt.Errorf("couldn't find mx for %s: %s", domain, err)
+			continue
+		}
+
+		domainMsg := restrictRecipients(msg, recipients)
+
+		delivered := false
+		var domainErr error
+		for _, mx := range mxs {
+			addr := strings.TrimSuffix(mx.Host, ".") + ":25"
+			if err := smtp.SendMail(addr, nil, domainMsg.From(), domainMsg.Recipients(), []byte(domainMsg.Contents())); err != nil {
+				c.Logger.Warnf("delivery to %s failed: %s", addr, err)
+				domainErr = err
+				continue
+			}
+			delivered = true
+			break
+		}
+		if !delivered {
+			failed = append(failed, recipients...)
+			lastErr = domainErr
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PartialSendError{Recipients: failed, Err: lastErr}
+	}
+	return nil
+}
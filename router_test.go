@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type stubMessage struct {
+	from       string
+	recipients []string
+	contents   string
+}
+
+func (m *stubMessage) From() string         { return m.from }
+func (m *stubMessage) Recipients() []string { return m.recipients }
+func (m *stubMessage) Contents() string     { return m.contents }
+
+type stubCourier struct {
+	sent     [][]string
+	failWith error
+}
+
+func (c *stubCourier) Send(msg OutgoingMessage) error {
+	c.sent = append(c.sent, msg.Recipients())
+	return c.failWith
+}
+
+func TestRouterSendPartitionsRecipientsPerCourier(t *testing.T) {
+	a, b, def := &stubCourier{}, &stubCourier{}, &stubCourier{}
+
+	router := NewRouter(def)
+	router.Routes["a.example"] = a
+	router.Routes["b.example"] = b
+
+	msg := &stubMessage{
+		from:       "sender@example.com",
+		recipients: []string{"one@a.example", "two@b.example", "three@a.example", "four@unrouted.example"},
+	}
+
+	if err := router.Send(msg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertSentOnce(t, a, []string{"one@a.example", "three@a.example"})
+	assertSentOnce(t, b, []string{"two@b.example"})
+	assertSentOnce(t, def, []string{"four@unrouted.example"})
+}
+
+func TestRouterSendFallsBackToDefaultWithNoRoutes(t *testing.T) {
+	def := &stubCourier{}
+	router := NewRouter(def)
+
+	msg := &stubMessage{from: "sender@example.com", recipients: []string{"a@example.com", "b@example.com"}}
+
+	if err := router.Send(msg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	assertSentOnce(t, def, []string{"a@example.com", "b@example.com"})
+}
+
+func TestRouterSendTriesEveryCourierAndReportsOnlyFailedRecipients(t *testing.T) {
+	boom := errors.New("boom")
+	a := &stubCourier{failWith: boom}
+	b := &stubCourier{}
+
+	router := NewRouter(b)
+	router.Routes["a.example"] = a
+
+	msg := &stubMessage{
+		from:       "sender@example.com",
+		recipients: []string{"one@a.example", "two@example.com"},
+	}
+
+	err := router.Send(msg)
+	if err == nil {
+		t.Fatalf("expected an error when one courier fails")
+	}
+
+	// b's courier must still have been tried even though a's failed.
+	assertSentOnce(t, b, []string{"two@example.com"})
+
+	var partial *PartialSendError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected a *PartialSendError, got %T: %s", err, err)
+	}
+	if !reflect.DeepEqual(partial.Recipients, []string{"one@a.example"}) {
+		t.Errorf("PartialSendError.Recipients = %v, want only the recipient a's courier failed on", partial.Recipients)
+	}
+}
+
+func assertSentOnce(t *testing.T, c *stubCourier, want []string) {
+	t.Helper()
+
+	if len(c.sent) != 1 {
+		t.Fatalf("courier got %d Send calls, want 1: %v", len(c.sent), c.sent)
+	}
+
+	got := append([]string{}, c.sent[0]...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("courier got recipients %v, want %v", got, want)
+	}
+}
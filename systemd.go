@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hut8labs/failmail/logging"
+)
+
+// systemdLog tags output from the systemd integration (socket activation,
+// readiness, and watchdog notifications).
+var systemdLog = logger("systemd")
+
+// systemdListener adopts a file descriptor passed by systemd socket
+// activation (LISTEN_FDS/LISTEN_PID), so failmail can run as a
+// socket-activated service without dropping connections across restarts.
+// It returns nil, nil if activation wasn't used, in which case the caller
+// should fall back to net.Listen.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+
+	// systemd hands off fds starting at 3 (after stdin/stdout/stderr).
+	const firstFD = 3
+	file := os.NewFile(uintptr(firstFD), "LISTEN_FD_3")
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't adopt socket-activated listener: %s", err)
+	}
+
+	systemdLog.Infof("adopted socket-activated listener from systemd")
+	return listener, nil
+}
+
+// notify sends state to $NOTIFY_SOCKET, if set. It's a no-op when failmail
+// isn't running under systemd.
+func notify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		systemdLog.Warnf("couldn't notify systemd: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		systemdLog.Warnf("couldn't notify systemd: %s", err)
+	}
+}
+
+// reloadRequested records whether the `run` loop's shutdown path was
+// entered because of an actual reload request (e.g. SIGHUP), as opposed to
+// a plain termination -- both take the same "flush and close `sending`"
+// path, but only the former should be reported to systemd as a reload.
+var reloadRequested int32
+
+// markReloadRequested records that `run`'s done case saw a Reload request.
+func markReloadRequested() {
+	atomic.StoreInt32(&reloadRequested, 1)
+}
+
+// reloadWasRequested reports whether markReloadRequested has been called.
+func reloadWasRequested() bool {
+	return atomic.LoadInt32(&reloadRequested) != 0
+}
+
+// lastTick is updated every time the main `run` loop's ticker fires, so the
+// watchdog goroutine can tell a wedged main loop from a healthy one.
+var lastTick int64
+
+// markTick records that the run loop just completed a tick.
+func markTick() {
+	atomic.StoreInt64(&lastTick, time.Now().UnixNano())
+}
+
+// runWatchdog pings $NOTIFY_SOCKET with WATCHDOG=1 at half of
+// $WATCHDOG_USEC's interval, but only while the run loop's tick has fired
+// within the last full interval, so a wedged main loop actually trips the
+// watchdog instead of being kept alive by a goroutine that's still running.
+func runWatchdog(done <-chan TerminationRequest) {
+	defer logging.PanicHandler(systemdLog)
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond
+	tick := time.NewTicker(interval / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			last := atomic.LoadInt64(&lastTick)
+			if last == 0 || time.Since(time.Unix(0, last)) > interval {
+				systemdLog.Warnf("run loop tick is stale, not pinging watchdog")
+				continue
+			}
+			notify("WATCHDOG=1")
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarkTickRecordsRecentTime(t *testing.T) {
+	before := time.Now()
+	markTick()
+	after := time.Now()
+
+	last := time.Unix(0, lastTick)
+	if last.Before(before) || last.After(after) {
+		t.Errorf("markTick() recorded %v, want between %v and %v", last, before, after)
+	}
+}
+
+func TestReloadRequestedFlag(t *testing.T) {
+	reloadRequested = 0
+
+	if reloadWasRequested() {
+		t.Fatalf("expected reloadWasRequested() to be false before markReloadRequested")
+	}
+
+	markReloadRequested()
+
+	if !reloadWasRequested() {
+		t.Errorf("expected reloadWasRequested() to be true after markReloadRequested")
+	}
+}